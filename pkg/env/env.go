@@ -15,44 +15,113 @@
 package env
 
 import (
+	"bytes"
 	"fmt"
-	"html/template"
 	"os"
 	"path/filepath"
 	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
 
 	v2 "github.com/alibaba/sealer/types/api/v2"
-	"github.com/alibaba/sealer/utils"
 )
 
 const templateSufix = ".tmpl"
 
 type Interface interface {
-	// WrapperShell :If host already set env like DATADISK=/data
-	// This function add env to the shell, like:
-	// Input shell: cat /etc/hosts
-	// Output shell: DATADISK=/data cat /etc/hosts
-	// So that you can get env values in you shell script
+	// WrapperShell wraps shell so it runs with the host's env (including
+	// any resolved vault://, sops:// or file:// secrets) set in its
+	// environment. BREAKING CHANGE from the original "KEY=VAL cmd" prefix
+	// format: the returned string is now a full "/bin/sh <<'SEALER_ENV_EOF'
+	// ... SEALER_ENV_EOF" here-doc that exports each var before running
+	// shell, so secret values never appear in a `ps` listing of the
+	// command. Callers must run the returned string as-is (e.g. via a
+	// remote exec) rather than splicing it as a prefix onto more shell
+	// text, since it is no longer a single inline command line.
 	WrapperShell(host, shell string) string
-	// RenderAll :render env to all the files in dir
+	// RenderAll :render env to all the files in dir, using Sprig's
+	// template function library plus a ".sealer" context value exposing
+	// host/cluster metadata. See Strict for missing-key behaviour.
 	RenderAll(host, dir string) error
+	// RenderFile renders a single template file, used where only one
+	// file needs rendering, e.g. kubeadm/manifest generation.
+	RenderFile(host, in, out string) error
+	// RenderAllWithSnippets behaves like RenderAll, but additionally
+	// extracts "# [START region_tag]" / "# [END region_tag]" blocks into
+	// their own file under snippetOutDir, stripping the markers from the
+	// primary rendered output. See snippet.go.
+	RenderAllWithSnippets(host, dir, snippetOutDir string) error
+	// LoadEnvFiles reads KEY=VALUE pairs from external files (dotenv, YAML,
+	// HCL or JSON, selected by extension) and merges them into the
+	// cluster's global env, see getHostEnv for the merge precedence. An
+	// "EnvFile=path" entry in Spec.Env or a host's Env (v2.Host/v2.Cluster
+	// have no dedicated field for this) is expanded the same way
+	// automatically by getHostEnv, so a Clusterfile can point at a file
+	// without an explicit LoadEnvFiles call.
+	LoadEnvFiles(paths ...string) error
+	// LoadHostEnvFiles behaves like LoadEnvFiles, but merges the loaded
+	// entries into a single host's Env instead of the cluster-global
+	// Spec.Env.
+	LoadHostEnvFiles(hostIP string, paths ...string) error
 }
 
 type processor struct {
 	*v2.Cluster
+	// strict fails rendering when a template references a key that is
+	// missing from the env, instead of silently rendering "<no value>".
+	strict bool
 }
 
-func NewEnvProcessor(cluster *v2.Cluster) Interface {
-	return &processor{cluster}
+// Option configures a processor returned by NewEnvProcessor.
+type Option func(*processor)
+
+// Strict makes RenderAll/RenderFile fail when a template references an
+// env key that isn't set, instead of silently rendering "<no value>".
+func Strict() Option {
+	return func(p *processor) {
+		p.strict = true
+	}
 }
 
+func NewEnvProcessor(cluster *v2.Cluster, opts ...Option) Interface {
+	p := &processor{Cluster: cluster}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// WrapperShell exports the host env inside a here-doc instead of
+// prefixing "KEY=VAL " onto shell, so resolved secret values never show
+// up in a `ps` listing of the command being run.
 func (p *processor) WrapperShell(host, shell string) string {
-	var env string
-	for k, v := range p.getHostEnv(host) {
-		env = fmt.Sprintf("%s%s=%s ", env, k, v)
+	env, err := p.getHostEnv(host)
+	if err != nil {
+		// getHostEnv fails when an EnvFile can't be loaded or a secret
+		// ref can't be resolved, and either way that means
+		// every var (not just the failing one) is about to be dropped;
+		// WrapperShell can't return an error, so log it rather than
+		// silently running shell with no env at all, mirroring the
+		// reporting pattern in watcher.go's handleError.
+		fmt.Fprintf(os.Stderr, "env: failed to resolve env for host [%s], running without it: %v\n", host, err)
+		return shell
 	}
 
-	return fmt.Sprintf("%s%s", env, shell)
+	var b strings.Builder
+	b.WriteString("/bin/sh <<'SEALER_ENV_EOF'\n")
+	for k, v := range env {
+		fmt.Fprintf(&b, "export %s=%s\n", k, shellQuote(fmt.Sprintf("%v", v)))
+	}
+	b.WriteString(shell)
+	b.WriteString("\nSEALER_ENV_EOF")
+	return b.String()
+}
+
+// shellQuote wraps s in single quotes, escaping any single quote it
+// contains, so it can be safely used as a POSIX shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
 
 func (p *processor) RenderAll(host, dir string) error {
@@ -63,24 +132,81 @@ func (p *processor) RenderAll(host, dir string) error {
 		if info.IsDir() || !strings.HasSuffix(info.Name(), templateSufix) {
 			return nil
 		}
-		writer, err := os.OpenFile(strings.TrimSuffix(path, templateSufix), os.O_CREATE|os.O_RDWR, os.ModePerm)
-		if err != nil {
-			return fmt.Errorf("failed to open file [%s] when render env: %v", path, err)
-		}
-		defer func() {
-			_ = writer.Close()
-		}()
-		t, err := template.ParseFiles(path)
-		if err != nil {
-			return fmt.Errorf("failed to create template: %s %v", path, err)
-		}
-		if err := t.Execute(writer, p.getHostEnv(host)); err != nil {
-			return fmt.Errorf("failed to render env template: %s %v", path, err)
-		}
-		return nil
+		return p.renderTemplateFile(host, path, strings.TrimSuffix(path, templateSufix))
 	})
 }
 
+func (p *processor) RenderFile(host, in, out string) error {
+	return p.renderTemplateFile(host, in, out)
+}
+
+func (p *processor) renderTemplateFile(host, in, out string) error {
+	rendered, err := p.renderTemplate(host, in)
+	if err != nil {
+		return err
+	}
+
+	writer, err := os.OpenFile(out, os.O_CREATE|os.O_TRUNC|os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to open file [%s] when render env: %v", out, err)
+	}
+	defer func() {
+		_ = writer.Close()
+	}()
+
+	if _, err := writer.Write(rendered); err != nil {
+		return fmt.Errorf("failed to render env template: %s:%s %v", in, filepath.Base(in), err)
+	}
+	return nil
+}
+
+// renderTemplate renders the template file in for host and returns the
+// result, without writing it anywhere.
+func (p *processor) renderTemplate(host, in string) ([]byte, error) {
+	t := template.New(filepath.Base(in)).Funcs(sprig.TxtFuncMap())
+	if p.strict {
+		t = t.Option("missingkey=error")
+	}
+	t, err := t.ParseFiles(in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create template: %s %v", in, err)
+	}
+
+	data, err := p.getHostEnv(host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve env for host [%s]: %v", host, err)
+	}
+	data["sealer"] = p.sealerContext(host)
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render env template: %s:%s %v", in, filepath.Base(in), err)
+	}
+	return buf.Bytes(), nil
+}
+
+// sealerContext builds the ".sealer" template value, exposing host and
+// cluster metadata so templates can branch on node role, e.g.
+// {{ if has "master" .sealer.Roles }}.
+func (p *processor) sealerContext(hostIP string) map[string]interface{} {
+	ctx := map[string]interface{}{
+		"ClusterName": p.Name,
+		"IP":          hostIP,
+		"Hostname":    hostIP,
+		"Roles":       []string{},
+	}
+
+	for _, host := range p.Spec.Hosts {
+		for _, ip := range host.IPS {
+			if ip == hostIP {
+				ctx["Roles"] = host.Roles
+			}
+		}
+	}
+
+	return ctx
+}
+
 /*
 func sameKey(keysrc string, list []string) bool {
 	s := strings.SplitN(keysrc, "=", 2)
@@ -96,9 +222,22 @@ func sameKey(keysrc string, list []string) bool {
 }
 */
 
+// mergeList appends the entries of src whose key isn't already present
+// in dst, so dst always wins on a per-key basis (not just on an exact
+// "KEY=VALUE" string match). A key that's merely repeated within src
+// itself (e.g. two "IP=..." lines meant to become a list, see
+// convertEnv) is left alone and still accumulates as intended; it's only
+// skipped when dst already carries that key with any value.
 func mergeList(dst, src []string) []string {
+	existing := map[string]bool{}
+	for _, s := range dst {
+		if k, _, ok := splitKV(s); ok {
+			existing[k] = true
+		}
+	}
+
 	for _, s := range src {
-		if utils.InList(s, dst) {
+		if k, _, ok := splitKV(s); ok && existing[k] {
 			continue
 		}
 		dst = append(dst, s)
@@ -106,8 +245,21 @@ func mergeList(dst, src []string) []string {
 	return dst
 }
 
-// Merge the host ENV and global env, the host env will overwrite cluster.Spec.Env
-func (p *processor) getHostEnv(hostIP string) (env map[string]interface{}) {
+// splitKV splits a "KEY=VALUE" env entry. Entries without "=" (not a
+// valid env line) report ok=false, same as convertEnv already treats them.
+func splitKV(s string) (key, value string, ok bool) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return "", "", false
+	}
+	return kv[0], kv[1], true
+}
+
+// Merge the host ENV and global env, the host env will overwrite cluster.Spec.Env.
+// Each side's "EnvFile=path" entries (see expandEnvFiles) are expanded before
+// the merge so a per-host EnvFile can't shadow a cluster-wide one, then any
+// vault://, sops:// or file:// secret references in the result are resolved.
+func (p *processor) getHostEnv(hostIP string) (map[string]interface{}, error) {
 	var hostEnv []string
 
 	for _, host := range p.Spec.Hosts {
@@ -118,9 +270,23 @@ func (p *processor) getHostEnv(hostIP string) (env map[string]interface{}) {
 		}
 	}
 
-	hostEnv = mergeList(hostEnv, p.Spec.Env)
+	// Expand each source's "EnvFile=" directives independently before
+	// merging: EnvFile isn't a real env var with override semantics, so
+	// running it through mergeList's key-dedup first would make a
+	// host-level EnvFile silently discard a cluster-level one just
+	// because they share the "EnvFile" key.
+	hostEnv, err := expandEnvFiles(hostEnv)
+	if err != nil {
+		return nil, err
+	}
+	specEnv, err := expandEnvFiles(p.Spec.Env)
+	if err != nil {
+		return nil, err
+	}
+
+	hostEnv = mergeList(hostEnv, specEnv)
 
-	return convertEnv(hostEnv)
+	return resolveSecrets(convertEnv(hostEnv))
 }
 
 // Covert Env []string to map[string]interface{}, example [IP=127.0.0.1,IP=192.160.0.2,Key=value] will convert to {IP:[127.0.0.1,192.168.0.2],key:value}
@@ -148,4 +314,4 @@ func convertEnv(envList []string) (env map[string]interface{}) {
 	}
 
 	return
-}
\ No newline at end of file
+}
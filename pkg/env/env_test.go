@@ -0,0 +1,156 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package env
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v2 "github.com/alibaba/sealer/types/api/v2"
+)
+
+func testCluster() *v2.Cluster {
+	return &v2.Cluster{
+		Name: "my-cluster",
+		Spec: v2.ClusterSpec{
+			Env: []string{"CLUSTER_WIDE=1"},
+			Hosts: []v2.Host{
+				{IPS: []string{"1.2.3.4"}, Roles: []string{"master"}, Env: []string{"NODE=master-1"}},
+				{IPS: []string{"5.6.7.8"}, Roles: []string{"node"}, Env: []string{"NODE=worker-1"}},
+			},
+		},
+	}
+}
+
+func TestRenderFileWritesSingleFile(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "a.yaml.tmpl")
+	out := filepath.Join(dir, "a.yaml")
+	if err := os.WriteFile(in, []byte("node: {{ .NODE }}\ncluster: {{ .CLUSTER_WIDE }}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewEnvProcessor(testCluster())
+	if err := p.RenderFile("1.2.3.4", in, out); err != nil {
+		t.Fatalf("RenderFile returned error: %v", err)
+	}
+
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "node: master-1\ncluster: 1\n"
+	if string(got) != want {
+		t.Errorf("rendered file = %q, want %q", got, want)
+	}
+}
+
+func TestRenderFileStrictModeErrorsOnMissingKey(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "a.yaml.tmpl")
+	out := filepath.Join(dir, "a.yaml")
+	if err := os.WriteFile(in, []byte("node: {{ .NODE }}\nmissing: {{ .DOES_NOT_EXIST }}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewEnvProcessor(testCluster(), Strict())
+	if err := p.RenderFile("1.2.3.4", in, out); err == nil {
+		t.Error("expected an error in strict mode for a missing key, got nil")
+	}
+}
+
+func TestRenderFileNonStrictToleratesMissingKey(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "a.yaml.tmpl")
+	out := filepath.Join(dir, "a.yaml")
+	if err := os.WriteFile(in, []byte("missing: {{ .DOES_NOT_EXIST }}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewEnvProcessor(testCluster())
+	if err := p.RenderFile("1.2.3.4", in, out); err != nil {
+		t.Fatalf("RenderFile returned error outside strict mode: %v", err)
+	}
+}
+
+func TestRenderFileSealerContextRoleBranching(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "a.yaml.tmpl")
+	tmpl := `role: {{ if has "master" .sealer.Roles }}master{{ else }}worker{{ end }}
+ip: {{ .sealer.IP }}
+cluster: {{ .sealer.ClusterName }}
+`
+	if err := os.WriteFile(in, []byte(tmpl), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewEnvProcessor(testCluster())
+
+	masterOut := filepath.Join(dir, "master.yaml")
+	if err := p.RenderFile("1.2.3.4", in, masterOut); err != nil {
+		t.Fatalf("RenderFile returned error: %v", err)
+	}
+	got, err := os.ReadFile(masterOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), "role: master") || !strings.Contains(string(got), "ip: 1.2.3.4") ||
+		!strings.Contains(string(got), "cluster: my-cluster") {
+		t.Errorf("rendered master output = %q", got)
+	}
+
+	workerOut := filepath.Join(dir, "worker.yaml")
+	if err := p.RenderFile("5.6.7.8", in, workerOut); err != nil {
+		t.Fatalf("RenderFile returned error: %v", err)
+	}
+	got, err = os.ReadFile(workerOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), "role: worker") {
+		t.Errorf("rendered worker output = %q, want role: worker", got)
+	}
+}
+
+func TestRenderAllRendersAllTemplatesUnderDir(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "sub")
+	if err := os.MkdirAll(sub, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "top.yaml.tmpl"), []byte("{{ .NODE }}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sub, "nested.yaml.tmpl"), []byte("{{ .NODE }}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewEnvProcessor(testCluster())
+	if err := p.RenderAll("1.2.3.4", dir); err != nil {
+		t.Fatalf("RenderAll returned error: %v", err)
+	}
+
+	for _, f := range []string{filepath.Join(dir, "top.yaml"), filepath.Join(sub, "nested.yaml")} {
+		got, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatalf("expected rendered file %s: %v", f, err)
+		}
+		if string(got) != "master-1" {
+			t.Errorf("%s = %q, want master-1", f, got)
+		}
+	}
+}
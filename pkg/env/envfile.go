@@ -0,0 +1,176 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package env
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	"gopkg.in/yaml.v2"
+
+	"github.com/alibaba/sealer/utils"
+)
+
+// envFileKey is the Env entry key that names an external file to load,
+// e.g. "EnvFile=/etc/sealer/app.env". v2.Cluster/v2.Host have no
+// dedicated field for this, so it piggybacks on the existing Env
+// []string the same way secret refs piggyback on plain values (see
+// secret.go); expandEnvFiles is what actually expands it.
+const envFileKey = "EnvFile"
+
+// LoadEnvFiles reads env vars from external files and merges them into
+// the cluster's global env, so large env sets can live in versioned
+// files instead of being stuffed into the Clusterfile. The format is
+// selected by file extension: .env/no extension (dotenv KEY=VALUE with
+// "#" comments and quoted values), .yaml/.yml, .json and .hcl. Keys
+// already set in Spec.Env or on a matching host take precedence, the
+// same host-overrides-cluster rule getHostEnv already applies.
+func (p *processor) LoadEnvFiles(paths ...string) error {
+	for _, path := range paths {
+		kvs, err := parseEnvFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to load env file [%s]: %v", path, err)
+		}
+		p.Spec.Env = mergeList(p.Spec.Env, kvs)
+	}
+	return nil
+}
+
+// LoadHostEnvFiles behaves like LoadEnvFiles, but merges the loaded
+// entries into the Env of the host whose IPS contains hostIP, instead of
+// the cluster-global Spec.Env, so per-host config/secrets can live in
+// their own file.
+func (p *processor) LoadHostEnvFiles(hostIP string, paths ...string) error {
+	for i := range p.Spec.Hosts {
+		host := &p.Spec.Hosts[i]
+		if !utils.InList(hostIP, host.IPS) {
+			continue
+		}
+		for _, path := range paths {
+			kvs, err := parseEnvFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to load env file [%s] for host [%s]: %v", path, hostIP, err)
+			}
+			host.Env = mergeList(host.Env, kvs)
+		}
+		return nil
+	}
+	return fmt.Errorf("no such host: %s", hostIP)
+}
+
+// expandEnvFiles replaces each "EnvFile=path" entry in envList with the
+// KV pairs parsed from path, merged in so that any entry already present
+// in envList takes precedence over the same key coming from the file.
+func expandEnvFiles(envList []string) ([]string, error) {
+	var kept, fromFiles []string
+
+	for _, e := range envList {
+		k, v, ok := splitKV(e)
+		if !ok || k != envFileKey {
+			kept = append(kept, e)
+			continue
+		}
+		kvs, err := parseEnvFile(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load env file [%s]: %v", v, err)
+		}
+		fromFiles = mergeList(fromFiles, kvs)
+	}
+
+	return mergeList(kept, fromFiles), nil
+}
+
+func parseEnvFile(path string) ([]string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return parseStructuredEnvFile(path, yaml.Unmarshal)
+	case ".json":
+		return parseStructuredEnvFile(path, json.Unmarshal)
+	case ".hcl":
+		return parseStructuredEnvFile(path, hcl.Unmarshal)
+	default:
+		return parseDotEnvFile(path)
+	}
+}
+
+// parseDotEnvFile parses a dotenv-style file: one KEY=VALUE pair per
+// line, blank lines and "#" comments ignored, values may be wrapped in
+// single or double quotes.
+func parseDotEnvFile(path string) ([]string, error) {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var kvs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		kvs = append(kvs, fmt.Sprintf("%s=%s", strings.TrimSpace(kv[0]), unquote(strings.TrimSpace(kv[1]))))
+	}
+	return kvs, scanner.Err()
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 {
+		if (s[0] == '"' && s[len(s)-1] == '"') || (s[0] == '\'' && s[len(s)-1] == '\'') {
+			return s[1 : len(s)-1]
+		}
+	}
+	return s
+}
+
+// parseStructuredEnvFile reads a key/value (or key/list) document with
+// the given unmarshal func and flattens it to the []string "KEY=VALUE"
+// form used everywhere else in this package, so list-valued keys fall
+// through convertEnv the same way repeated KEY=VALUE lines already do.
+func parseStructuredEnvFile(path string, unmarshal func([]byte, interface{}) error) ([]string, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	raw := make(map[string]interface{})
+	if err := unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	var kvs []string
+	for k, v := range raw {
+		switch val := v.(type) {
+		case []interface{}:
+			for _, item := range val {
+				kvs = append(kvs, fmt.Sprintf("%s=%v", k, item))
+			}
+		default:
+			kvs = append(kvs, fmt.Sprintf("%s=%v", k, val))
+		}
+	}
+	return kvs, nil
+}
@@ -0,0 +1,206 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package env
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	v2 "github.com/alibaba/sealer/types/api/v2"
+)
+
+func TestMergeListOverridesByKeyNotByExactString(t *testing.T) {
+	dst := []string{"DATADISK=/data"}
+	src := []string{"DATADISK=/mnt/data", "NTP=ntp1"}
+
+	got := mergeList(dst, src)
+
+	want := []string{"DATADISK=/data", "NTP=ntp1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeList = %v, want %v", got, want)
+	}
+
+	// Regression check: convertEnv must not turn DATADISK into a list
+	// just because src carried a different value for the same key.
+	env := convertEnv(got)
+	if v, ok := env["DATADISK"].(string); !ok || v != "/data" {
+		t.Errorf("env[DATADISK] = %#v, want the string \"/data\"", env["DATADISK"])
+	}
+}
+
+func TestMergeListPreservesIntraListDuplicates(t *testing.T) {
+	dst := []string{"IP=127.0.0.1", "IP=192.168.0.2"}
+	src := []string{"IP=10.0.0.1"}
+
+	got := mergeList(dst, src)
+
+	want := []string{"IP=127.0.0.1", "IP=192.168.0.2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeList = %v, want %v", got, want)
+	}
+}
+
+func TestSplitKV(t *testing.T) {
+	cases := []struct {
+		in       string
+		key, val string
+		wantOK   bool
+	}{
+		{"KEY=VALUE", "KEY", "VALUE", true},
+		{"KEY=", "KEY", "", true},
+		{"KEY=A=B", "KEY", "A=B", true},
+		{"NOTANENV", "", "", false},
+	}
+	for _, c := range cases {
+		k, v, ok := splitKV(c.in)
+		if ok != c.wantOK || k != c.key || v != c.val {
+			t.Errorf("splitKV(%q) = (%q, %q, %v), want (%q, %q, %v)", c.in, k, v, ok, c.key, c.val, c.wantOK)
+		}
+	}
+}
+
+func TestExpandEnvFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "extra.env")
+	if err := os.WriteFile(path, []byte("FOO=bar\nBAZ=qux\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	in := []string{"EnvFile=" + path, "FOO=inline-wins"}
+
+	out, err := expandEnvFiles(in)
+	if err != nil {
+		t.Fatalf("expandEnvFiles returned error: %v", err)
+	}
+
+	env := convertEnv(out)
+	if env["FOO"] != "inline-wins" {
+		t.Errorf("env[FOO] = %v, want inline-wins (inline entry must win over EnvFile)", env["FOO"])
+	}
+	if env["BAZ"] != "qux" {
+		t.Errorf("env[BAZ] = %v, want qux", env["BAZ"])
+	}
+}
+
+// TestGetHostEnvExpandsBothHostAndSpecEnvFiles is a regression test: a
+// host-level EnvFile must not make getHostEnv skip a cluster-level
+// EnvFile just because both entries share the "EnvFile" key.
+func TestGetHostEnvExpandsBothHostAndSpecEnvFiles(t *testing.T) {
+	dir := t.TempDir()
+	hostFile := filepath.Join(dir, "host.env")
+	specFile := filepath.Join(dir, "spec.env")
+	if err := os.WriteFile(hostFile, []byte("FROM_HOST=1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(specFile, []byte("FROM_SPEC=1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := &v2.Cluster{
+		Spec: v2.ClusterSpec{
+			Env: []string{"EnvFile=" + specFile},
+			Hosts: []v2.Host{
+				{IPS: []string{"1.2.3.4"}, Env: []string{"EnvFile=" + hostFile}},
+			},
+		},
+	}
+
+	p := &processor{Cluster: cluster}
+	got, err := p.getHostEnv("1.2.3.4")
+	if err != nil {
+		t.Fatalf("getHostEnv returned error: %v", err)
+	}
+
+	if got["FROM_HOST"] != "1" {
+		t.Errorf(`got["FROM_HOST"] = %v, want "1"`, got["FROM_HOST"])
+	}
+	if got["FROM_SPEC"] != "1" {
+		t.Errorf(`got["FROM_SPEC"] = %v, want "1" (cluster-level EnvFile must still load)`, got["FROM_SPEC"])
+	}
+}
+
+func TestExpandEnvFilesMissingFile(t *testing.T) {
+	if _, err := expandEnvFiles([]string{"EnvFile=/no/such/file.env"}); err == nil {
+		t.Error("expected error for missing EnvFile target, got nil")
+	}
+}
+
+func TestParseDotEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.env")
+	content := "# comment\n\nKEY=value\nQUOTED=\"hello world\"\nSINGLE='single'\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	kvs, err := parseEnvFile(path)
+	if err != nil {
+		t.Fatalf("parseEnvFile returned error: %v", err)
+	}
+
+	env := convertEnv(kvs)
+	if env["KEY"] != "value" || env["QUOTED"] != "hello world" || env["SINGLE"] != "single" {
+		t.Errorf("env = %#v", env)
+	}
+}
+
+func TestParseYAMLEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.yaml")
+	content := "KEY: value\nLIST:\n  - a\n  - b\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	kvs, err := parseEnvFile(path)
+	if err != nil {
+		t.Fatalf("parseEnvFile returned error: %v", err)
+	}
+
+	env := convertEnv(kvs)
+	if env["KEY"] != "value" {
+		t.Errorf("env[KEY] = %v, want value", env["KEY"])
+	}
+	list, ok := env["LIST"].([]string)
+	if !ok {
+		t.Fatalf("env[LIST] = %#v, want []string", env["LIST"])
+	}
+	sort.Strings(list)
+	if !reflect.DeepEqual(list, []string{"a", "b"}) {
+		t.Errorf("env[LIST] = %v, want [a b]", list)
+	}
+}
+
+func TestParseJSONEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.json")
+	content := `{"KEY": "value"}`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	kvs, err := parseEnvFile(path)
+	if err != nil {
+		t.Fatalf("parseEnvFile returned error: %v", err)
+	}
+
+	env := convertEnv(kvs)
+	if env["KEY"] != "value" {
+		t.Errorf("env[KEY] = %v, want value", env["KEY"])
+	}
+}
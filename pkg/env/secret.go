@@ -0,0 +1,298 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package env
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"go.mozilla.org/sops/v3/decrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// secretRefPattern matches a "KEY=scheme://path#lookup" env value, e.g.
+// "vault://secret/data/app#password" or "sops://path/to/file.yaml#key.subkey".
+var secretRefPattern = regexp.MustCompile(`^([a-z][a-z0-9+.-]*)://(.+)$`)
+
+// SecretProvider resolves a "scheme://path#lookup" reference to its
+// plaintext value. Provider selection is driven by the URL scheme.
+type SecretProvider interface {
+	// Scheme is the URL scheme this provider handles, e.g. "vault".
+	Scheme() string
+	// Resolve returns the secret value for ref, where ref is everything
+	// after "scheme://".
+	Resolve(ref string) (string, error)
+}
+
+// Renewable is implemented by providers that hold a lease which must be
+// periodically renewed, e.g. a Vault token.
+type Renewable interface {
+	// Refresh renews the provider's credentials/lease for ttl.
+	Refresh(ttl time.Duration) error
+}
+
+var secretProviders = map[string]SecretProvider{}
+
+// RegisterSecretProvider makes p available for env values of the form
+// "<p.Scheme()>://...". Built-in providers are registered in init().
+func RegisterSecretProvider(p SecretProvider) {
+	secretProviders[p.Scheme()] = p
+}
+
+func init() {
+	RegisterSecretProvider(&VaultProvider{})
+	RegisterSecretProvider(&SOPSProvider{})
+	RegisterSecretProvider(&FileProvider{})
+}
+
+// RefreshSecrets renews the lease/credentials of every registered
+// provider that supports it, so long-running installs can keep e.g. a
+// Vault lease alive without re-authenticating.
+func RefreshSecrets(ttl time.Duration) error {
+	for scheme, p := range secretProviders {
+		r, ok := p.(Renewable)
+		if !ok {
+			continue
+		}
+		if err := r.Refresh(ttl); err != nil {
+			return fmt.Errorf("failed to refresh secret provider [%s]: %v", scheme, err)
+		}
+	}
+	return nil
+}
+
+// resolveSecrets replaces any "scheme://path#lookup" string values in
+// env (including inside []string list values) with the secret they
+// reference, leaving ordinary values untouched.
+func resolveSecrets(env map[string]interface{}) (map[string]interface{}, error) {
+	for k, v := range env {
+		switch val := v.(type) {
+		case string:
+			resolved, err := resolveSecretValue(val)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve secret for key [%s]: %v", k, err)
+			}
+			env[k] = resolved
+		case []string:
+			for i, item := range val {
+				resolved, err := resolveSecretValue(item)
+				if err != nil {
+					return nil, fmt.Errorf("failed to resolve secret for key [%s]: %v", k, err)
+				}
+				val[i] = resolved
+			}
+			env[k] = val
+		}
+	}
+	return env, nil
+}
+
+func resolveSecretValue(val string) (string, error) {
+	m := secretRefPattern.FindStringSubmatch(val)
+	if m == nil {
+		return val, nil
+	}
+	provider, ok := secretProviders[m[1]]
+	if !ok {
+		return val, nil
+	}
+	return provider.Resolve(m[2])
+}
+
+// VaultProvider resolves "vault://<path>#<key>" references against a
+// HashiCorp Vault KV v1 or v2 mount, using the ambient VAULT_ADDR /
+// VAULT_TOKEN environment used by the official Vault client.
+type VaultProvider struct {
+	client *vaultapi.Client
+}
+
+func (p *VaultProvider) Scheme() string { return "vault" }
+
+func (p *VaultProvider) Resolve(ref string) (string, error) {
+	path, key, ok := cutLast(ref, '#')
+	if !ok {
+		return "", fmt.Errorf("vault secret ref %q missing \"#key\" suffix", ref)
+	}
+
+	if p.client == nil {
+		client, err := vaultapi.NewClient(vaultapi.DefaultConfig())
+		if err != nil {
+			return "", err
+		}
+		p.client = client
+	}
+	client := p.client
+
+	secret, err := client.Logical().Read(path)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil {
+		return "", fmt.Errorf("no such vault secret: %s", path)
+	}
+
+	data := secret.Data
+	// KV v2 nests the actual fields under a "data" key.
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no key %q", path, key)
+	}
+	return fmt.Sprintf("%v", value), nil
+}
+
+// Refresh renews the client's own token lease for ttl.
+func (p *VaultProvider) Refresh(ttl time.Duration) error {
+	if p.client == nil {
+		return nil
+	}
+	_, err := p.client.Auth().Token().RenewSelf(int(ttl.Seconds()))
+	return err
+}
+
+// SOPSProvider resolves "sops://<file>#<key.subkey>" references by
+// decrypting a Mozilla SOPS-encrypted YAML/JSON file and walking the
+// dotted key path.
+type SOPSProvider struct{}
+
+func (p *SOPSProvider) Scheme() string { return "sops" }
+
+func (p *SOPSProvider) Resolve(ref string) (string, error) {
+	path, key, ok := cutLast(ref, '#')
+	if !ok {
+		return "", fmt.Errorf("sops secret ref %q missing \"#key\" suffix", ref)
+	}
+
+	plain, err := decrypt.File(path, formatFromExt(path))
+	if err != nil {
+		return "", err
+	}
+
+	return lookupDottedKey(plain, path, key)
+}
+
+// FileProvider resolves "file://<path>#<key>" references against a
+// local encrypted file, decrypted with the key in SEALER_SECRET_KEY.
+// Unlike Vault/SOPS this never calls out to a remote service, so it's
+// the fallback for offline/air-gapped installs.
+type FileProvider struct{}
+
+func (p *FileProvider) Scheme() string { return "file" }
+
+func (p *FileProvider) Resolve(ref string) (string, error) {
+	path, key, ok := cutLast(ref, '#')
+	if !ok {
+		return "", fmt.Errorf("file secret ref %q missing \"#key\" suffix", ref)
+	}
+
+	plain, err := decryptLocalFile(path)
+	if err != nil {
+		return "", err
+	}
+	return lookupDottedKey(plain, path, key)
+}
+
+func cutLast(s string, sep byte) (before, after string, ok bool) {
+	i := strings.LastIndexByte(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+1:], true
+}
+
+func formatFromExt(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".json"):
+		return "json"
+	default:
+		return "yaml"
+	}
+}
+
+// lookupDottedKey parses plain as YAML and walks a dotted key path, e.g.
+// "key.subkey", returning the leaf value as a string.
+func lookupDottedKey(plain []byte, path, key string) (string, error) {
+	var tree map[string]interface{}
+	if err := yaml.Unmarshal(plain, &tree); err != nil {
+		return "", fmt.Errorf("failed to parse decrypted secret %s: %v", path, err)
+	}
+
+	var cur interface{} = tree
+	for _, part := range strings.Split(key, ".") {
+		var next interface{}
+		ok := false
+		// yaml.v2 decodes nested mappings as map[interface{}]interface{}.
+		switch m := cur.(type) {
+		case map[string]interface{}:
+			next, ok = m[part]
+		case map[interface{}]interface{}:
+			next, ok = m[part]
+		}
+		if !ok {
+			return "", fmt.Errorf("secret %s has no key %q", path, key)
+		}
+		cur = next
+	}
+	return fmt.Sprintf("%v", cur), nil
+}
+
+// decryptLocalFile decrypts a file previously sealed with AES-GCM under
+// the key in SEALER_SECRET_KEY (base64-encoded, 16/24/32 bytes), the
+// minimal "local encrypted file" backend for offline installs. The file
+// format is base64(nonce || ciphertext).
+func decryptLocalFile(path string) ([]byte, error) {
+	keyB64 := os.Getenv("SEALER_SECRET_KEY")
+	if keyB64 == "" {
+		return nil, fmt.Errorf("SEALER_SECRET_KEY is not set, cannot decrypt %s", path)
+	}
+	key, err := base64.StdEncoding.DecodeString(keyB64)
+	if err != nil {
+		return nil, fmt.Errorf("SEALER_SECRET_KEY is not valid base64: %v", err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid encrypted secret file: %v", path, err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("%s is too short to be a valid encrypted secret file", path)
+	}
+
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
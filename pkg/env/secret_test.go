@@ -0,0 +1,104 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package env
+
+import (
+	"fmt"
+	"testing"
+)
+
+// stubProvider is a SecretProvider used only to test scheme dispatch,
+// without reaching out to a real Vault/SOPS backend.
+type stubProvider struct {
+	scheme string
+	values map[string]string
+}
+
+func (s *stubProvider) Scheme() string { return s.scheme }
+
+func (s *stubProvider) Resolve(ref string) (string, error) {
+	v, ok := s.values[ref]
+	if !ok {
+		return "", fmt.Errorf("stub: no such ref %q", ref)
+	}
+	return v, nil
+}
+
+func TestResolveSecretValueDispatchesByScheme(t *testing.T) {
+	RegisterSecretProvider(&stubProvider{scheme: "teststub", values: map[string]string{
+		"path/to/app#password": "s3cr3t",
+	}})
+
+	got, err := resolveSecretValue("teststub://path/to/app#password")
+	if err != nil {
+		t.Fatalf("resolveSecretValue returned error: %v", err)
+	}
+	if got != "s3cr3t" {
+		t.Errorf("resolveSecretValue = %q, want s3cr3t", got)
+	}
+}
+
+func TestResolveSecretValueUnknownSchemePassesThrough(t *testing.T) {
+	got, err := resolveSecretValue("nosuchscheme://whatever")
+	if err != nil {
+		t.Fatalf("resolveSecretValue returned error: %v", err)
+	}
+	if got != "nosuchscheme://whatever" {
+		t.Errorf("resolveSecretValue = %q, want the value unchanged", got)
+	}
+}
+
+func TestResolveSecretValuePlainValuePassesThrough(t *testing.T) {
+	got, err := resolveSecretValue("/data")
+	if err != nil {
+		t.Fatalf("resolveSecretValue returned error: %v", err)
+	}
+	if got != "/data" {
+		t.Errorf("resolveSecretValue = %q, want /data", got)
+	}
+}
+
+func TestResolveSecretValuePropagatesProviderError(t *testing.T) {
+	RegisterSecretProvider(&stubProvider{scheme: "teststub2", values: map[string]string{}})
+
+	if _, err := resolveSecretValue("teststub2://missing#key"); err == nil {
+		t.Error("expected error for missing ref, got nil")
+	}
+}
+
+func TestResolveSecretsWalksMapAndListValues(t *testing.T) {
+	RegisterSecretProvider(&stubProvider{scheme: "teststub3", values: map[string]string{
+		"a#k": "resolved-a",
+	}})
+
+	env := map[string]interface{}{
+		"PLAIN":  "value",
+		"SECRET": "teststub3://a#k",
+		"LIST":   []string{"teststub3://a#k", "plain-item"},
+	}
+
+	out, err := resolveSecrets(env)
+	if err != nil {
+		t.Fatalf("resolveSecrets returned error: %v", err)
+	}
+
+	if out["SECRET"] != "resolved-a" {
+		t.Errorf(`out["SECRET"] = %v, want resolved-a`, out["SECRET"])
+	}
+	list, ok := out["LIST"].([]string)
+	if !ok || list[0] != "resolved-a" || list[1] != "plain-item" {
+		t.Errorf(`out["LIST"] = %#v`, out["LIST"])
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package env
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	regionStart = regexp.MustCompile(`^\s*#\s*\[START\s+(\S+)\]\s*$`)
+	regionEnd   = regexp.MustCompile(`^\s*#\s*\[END\s+(\S+)\]\s*$`)
+)
+
+// RenderAllWithSnippets renders every *.tmpl file under dir for host,
+// same as RenderAll, but also splits out "# [START tag]" ... "# [END
+// tag]" blocks into "<tag>" files under snippetOutDir so plugin authors
+// can keep one long install script as a single template while exposing
+// named fragments (e.g. kubeadm_init, cni_apply) that other sealer steps
+// or docs can reference. Region markers are stripped from the primary
+// output.
+func (p *processor) RenderAllWithSnippets(host, dir, snippetOutDir string) error {
+	if err := os.MkdirAll(snippetOutDir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create snippet dir [%s]: %v", snippetOutDir, err)
+	}
+
+	return filepath.Walk(dir, func(path string, info os.FileInfo, errIn error) error {
+		if errIn != nil {
+			return errIn
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), templateSufix) {
+			return nil
+		}
+
+		rendered, err := p.renderTemplate(host, path)
+		if err != nil {
+			return err
+		}
+
+		out, snippets, err := extractRegions(rendered)
+		if err != nil {
+			return fmt.Errorf("failed to extract regions from [%s]: %v", path, err)
+		}
+
+		outPath := strings.TrimSuffix(path, templateSufix)
+		if err := os.WriteFile(outPath, out, os.ModePerm); err != nil {
+			return fmt.Errorf("failed to write rendered file [%s]: %v", outPath, err)
+		}
+
+		for tag, snippet := range snippets {
+			snippetPath, err := safeSnippetPath(snippetOutDir, tag)
+			if err != nil {
+				return fmt.Errorf("refusing to write snippet from [%s]: %v", path, err)
+			}
+			if err := os.WriteFile(snippetPath, snippet, os.ModePerm); err != nil {
+				return fmt.Errorf("failed to write snippet [%s]: %v", snippetPath, err)
+			}
+		}
+		return nil
+	})
+}
+
+// safeSnippetPath validates a region tag taken from rendered template
+// output before it is used as a filename: tags may not be empty, contain
+// path separators or be "..", so a tag that happens to come from an
+// interpolated env value (e.g. "# [START {{ .SOME_VAR }}]") can't escape
+// snippetOutDir and overwrite an arbitrary file on disk.
+func safeSnippetPath(snippetOutDir, tag string) (string, error) {
+	if tag == "" || strings.ContainsAny(tag, `/\`) || tag == ".." {
+		return "", fmt.Errorf("invalid region tag %q", tag)
+	}
+
+	path := filepath.Join(snippetOutDir, tag)
+	base, err := filepath.Abs(snippetOutDir)
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if abs != base && !strings.HasPrefix(abs, base+string(filepath.Separator)) {
+		return "", fmt.Errorf("region tag %q escapes snippet dir", tag)
+	}
+	return path, nil
+}
+
+// extractRegions scans rendered line by line, collecting the content
+// between matching START/END markers into snippets keyed by region tag,
+// and returns rendered with all marker lines removed (the region bodies
+// stay in the primary output unchanged).
+func extractRegions(rendered []byte) (out []byte, snippets map[string][]byte, err error) {
+	snippets = map[string][]byte{}
+
+	var outBuf bytes.Buffer
+	var active []string // stack of open region tags, to support nesting
+	snippetBuf := map[string]*bytes.Buffer{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(rendered))
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if m := regionStart.FindStringSubmatch(line); m != nil {
+			tag := m[1]
+			if _, ok := snippetBuf[tag]; ok {
+				return nil, nil, fmt.Errorf("region %q started more than once", tag)
+			}
+			snippetBuf[tag] = &bytes.Buffer{}
+			active = append(active, tag)
+			continue
+		}
+		if m := regionEnd.FindStringSubmatch(line); m != nil {
+			tag := m[1]
+			if len(active) == 0 || active[len(active)-1] != tag {
+				return nil, nil, fmt.Errorf("region %q ended without a matching start", tag)
+			}
+			active = active[:len(active)-1]
+			snippets[tag] = snippetBuf[tag].Bytes()
+			continue
+		}
+
+		outBuf.WriteString(line)
+		outBuf.WriteByte('\n')
+		for _, tag := range active {
+			snippetBuf[tag].WriteString(line)
+			snippetBuf[tag].WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(active) != 0 {
+		return nil, nil, fmt.Errorf("region %q was never closed", active[len(active)-1])
+	}
+
+	return outBuf.Bytes(), snippets, nil
+}
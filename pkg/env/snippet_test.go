@@ -0,0 +1,115 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package env
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExtractRegions(t *testing.T) {
+	in := []byte(strings.Join([]string{
+		"before",
+		"# [START kubeadm_init]",
+		"kubeadm init",
+		"# [END kubeadm_init]",
+		"after",
+	}, "\n") + "\n")
+
+	out, snippets, err := extractRegions(in)
+	if err != nil {
+		t.Fatalf("extractRegions returned error: %v", err)
+	}
+
+	// Region markers are stripped, but the region's body stays in the
+	// primary output — snippets are an *additional* extraction, not a
+	// relocation.
+	wantOut := "before\nkubeadm init\nafter\n"
+	if string(out) != wantOut {
+		t.Errorf("out = %q, want %q", out, wantOut)
+	}
+
+	wantSnippet := "kubeadm init\n"
+	if string(snippets["kubeadm_init"]) != wantSnippet {
+		t.Errorf("snippets[kubeadm_init] = %q, want %q", snippets["kubeadm_init"], wantSnippet)
+	}
+}
+
+func TestExtractRegionsNested(t *testing.T) {
+	in := []byte(strings.Join([]string{
+		"# [START outer]",
+		"outer-before",
+		"# [START inner]",
+		"inner-line",
+		"# [END inner]",
+		"outer-after",
+		"# [END outer]",
+	}, "\n") + "\n")
+
+	_, snippets, err := extractRegions(in)
+	if err != nil {
+		t.Fatalf("extractRegions returned error: %v", err)
+	}
+
+	wantInner := "inner-line\n"
+	if string(snippets["inner"]) != wantInner {
+		t.Errorf("snippets[inner] = %q, want %q", snippets["inner"], wantInner)
+	}
+
+	wantOuter := "outer-before\ninner-line\nouter-after\n"
+	if string(snippets["outer"]) != wantOuter {
+		t.Errorf("snippets[outer] = %q, want %q", snippets["outer"], wantOuter)
+	}
+}
+
+func TestExtractRegionsUnclosedIsError(t *testing.T) {
+	in := []byte("# [START foo]\nline\n")
+	if _, _, err := extractRegions(in); err == nil {
+		t.Error("expected error for unclosed region, got nil")
+	}
+}
+
+func TestExtractRegionsMismatchedEndIsError(t *testing.T) {
+	in := []byte("# [START foo]\nline\n# [END bar]\n")
+	if _, _, err := extractRegions(in); err == nil {
+		t.Error("expected error for mismatched region end, got nil")
+	}
+}
+
+func TestSafeSnippetPathRejectsTraversal(t *testing.T) {
+	cases := []string{
+		"../../etc/passwd",
+		"..",
+		"a/../../b",
+		"/etc/passwd",
+		"sub/dir",
+		"",
+	}
+	for _, tag := range cases {
+		if _, err := safeSnippetPath("/tmp/snippets", tag); err == nil {
+			t.Errorf("safeSnippetPath(%q) = nil error, want error", tag)
+		}
+	}
+}
+
+func TestSafeSnippetPathAcceptsPlainTag(t *testing.T) {
+	path, err := safeSnippetPath("/tmp/snippets", "kubeadm_init")
+	if err != nil {
+		t.Fatalf("safeSnippetPath returned error: %v", err)
+	}
+	if path != "/tmp/snippets/kubeadm_init" {
+		t.Errorf("path = %q, want /tmp/snippets/kubeadm_init", path)
+	}
+}
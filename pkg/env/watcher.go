@@ -0,0 +1,196 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package env
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	v2 "github.com/alibaba/sealer/types/api/v2"
+)
+
+// WatchOptions configures a Watcher, similar to the fswatch-style trigger
+// config (delay, callback) used by tools like gosuv. There is no pattern
+// option: which files trigger a re-render is fixed, see relevant.
+type WatchOptions struct {
+	// Paths are the directories to watch. A change to a *.tmpl file or an
+	// env file (.env, .yaml, .yml, .json, .hcl) under them triggers a
+	// re-render; files outside Paths, such as an EnvFile elsewhere on
+	// disk, are not watched.
+	Paths []string
+	// Debounce coalesces bursts of filesystem events (e.g. an editor
+	// doing a save-as) into a single re-render. Defaults to 500ms.
+	Debounce time.Duration
+	// OnChange, if set, is called after a successful re-render for each
+	// affected host, e.g. to re-run WrapperShell remotely on that host.
+	OnChange func(host string) error
+	// OnError, if set, is called for every render error instead of it
+	// being silently swallowed. If nil, errors are logged to stderr so a
+	// broken template edit (easy to trigger once Strict is on) doesn't
+	// make the watcher go quiet with no indication anything failed.
+	OnError func(host, path string, err error)
+}
+
+// Watcher re-renders templates under WatchOptions.Paths whenever a
+// *.tmpl file or an env file under those paths changes. Each template is
+// rendered on its own, so no partials are included into it.
+type Watcher struct {
+	cluster   *v2.Cluster
+	processor Interface
+	opts      WatchOptions
+
+	fsWatcher *fsnotify.Watcher
+	stopCh    chan struct{}
+}
+
+// NewWatcher creates a Watcher for cluster that re-renders opts.Paths on
+// change. Call Start to begin watching and Stop to shut it down.
+func NewWatcher(cluster *v2.Cluster, opts WatchOptions) (*Watcher, error) {
+	if opts.Debounce <= 0 {
+		opts.Debounce = 500 * time.Millisecond
+	}
+
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file watcher: %v", err)
+	}
+
+	for _, p := range opts.Paths {
+		if err := addRecursive(fsWatcher, p); err != nil {
+			_ = fsWatcher.Close()
+			return nil, fmt.Errorf("failed to watch path [%s]: %v", p, err)
+		}
+	}
+
+	return &Watcher{
+		cluster:   cluster,
+		processor: NewEnvProcessor(cluster),
+		opts:      opts,
+		fsWatcher: fsWatcher,
+		stopCh:    make(chan struct{}),
+	}, nil
+}
+
+func addRecursive(w *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return w.Add(path)
+		}
+		return nil
+	})
+}
+
+// Start begins watching in a background goroutine until Stop is called.
+func (w *Watcher) Start() error {
+	go w.loop()
+	return nil
+}
+
+// Stop shuts down the watcher and releases its filesystem handles.
+func (w *Watcher) Stop() error {
+	close(w.stopCh)
+	return w.fsWatcher.Close()
+}
+
+func (w *Watcher) loop() {
+	var debounce *time.Timer
+
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case event, ok := <-w.fsWatcher.Events:
+			if !ok {
+				return
+			}
+			if !w.relevant(event) {
+				continue
+			}
+			if debounce == nil {
+				debounce = time.AfterFunc(w.opts.Debounce, w.render)
+			} else {
+				debounce.Reset(w.opts.Debounce)
+			}
+		case err, ok := <-w.fsWatcher.Errors:
+			if !ok {
+				return
+			}
+			w.handleError("", "", err)
+		}
+	}
+}
+
+// relevant reports whether event should trigger a re-render: writes,
+// creates and renames of *.tmpl files and of env files with a .env,
+// .yaml, .yml, .json or .hcl extension. A file that has a "<name>.tmpl"
+// beside it is rendered output, not an env source, and is ignored.
+func (w *Watcher) relevant(event fsnotify.Event) bool {
+	if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+		return false
+	}
+	if strings.HasSuffix(event.Name, templateSufix) {
+		return true
+	}
+	if _, err := os.Stat(event.Name + templateSufix); err == nil {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(event.Name)) {
+	case ".env", ".yaml", ".yml", ".json", ".hcl":
+		return true
+	}
+	return false
+}
+
+func (w *Watcher) render() {
+	for _, host := range w.cluster.Spec.Hosts {
+		for _, ip := range host.IPS {
+			failed := false
+			for _, path := range w.opts.Paths {
+				if err := w.processor.RenderAll(ip, path); err != nil {
+					failed = true
+					w.handleError(ip, path, err)
+				}
+			}
+			// keep watching regardless of failed: a broken template
+			// shouldn't stop future edits from being picked up.
+			if !failed && w.opts.OnChange != nil {
+				_ = w.opts.OnChange(ip)
+			}
+		}
+	}
+}
+
+// handleError reports a render or filesystem-watch failure via OnError
+// if set, otherwise to stderr, so neither is ever silently dropped. path
+// is "" for a watcher-internal error (not tied to one template).
+func (w *Watcher) handleError(host, path string, err error) {
+	if w.opts.OnError != nil {
+		w.opts.OnError(host, path, err)
+		return
+	}
+	if path == "" {
+		fmt.Fprintf(os.Stderr, "env: watcher error: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "env: failed to render [%s] for host [%s]: %v\n", path, host, err)
+}
@@ -0,0 +1,70 @@
+// Copyright © 2021 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package env
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestWatcherRelevant(t *testing.T) {
+	w := &Watcher{}
+
+	cases := []struct {
+		name string
+		op   fsnotify.Op
+		want bool
+	}{
+		{"values.tmpl", fsnotify.Write, true},
+		{"values.tmpl", fsnotify.Chmod, false},
+		{"extra.env", fsnotify.Create, true},
+		{"extra.yaml", fsnotify.Write, true},
+		{"notes.txt", fsnotify.Write, false},
+		{"values.tmpl", fsnotify.Remove, false},
+	}
+	for _, c := range cases {
+		got := w.relevant(fsnotify.Event{Name: c.name, Op: c.op})
+		if got != c.want {
+			t.Errorf("relevant({%s, %v}) = %v, want %v", c.name, c.op, got, c.want)
+		}
+	}
+}
+
+func TestWatcherHandleErrorUsesOnError(t *testing.T) {
+	var gotHost, gotPath string
+	var gotErr error
+
+	w := &Watcher{opts: WatchOptions{
+		OnError: func(host, path string, err error) {
+			gotHost, gotPath, gotErr = host, path, err
+		},
+	}}
+
+	sentinel := errors.New("boom")
+	w.handleError("1.2.3.4", "/tmp/x.tmpl", sentinel)
+
+	if gotHost != "1.2.3.4" || gotPath != "/tmp/x.tmpl" || gotErr != sentinel {
+		t.Errorf("OnError got (%q, %q, %v)", gotHost, gotPath, gotErr)
+	}
+}
+
+func TestWatcherHandleErrorFallsBackWithoutPanicking(t *testing.T) {
+	w := &Watcher{}
+	// No OnError set: must log to stderr rather than panic or block.
+	w.handleError("1.2.3.4", "/tmp/x.tmpl", errors.New("boom"))
+	w.handleError("", "", errors.New("fsnotify internal error"))
+}